@@ -0,0 +1,147 @@
+#!/usr/bin/env python3
+"""Check the collector definitions in config/.
+
+Each file is loaded and every branch is checked for known fields, valid
+column usages and metric names, and that its metric columns match the
+output columns of its query, in order. Branches that share a name are
+alternatives to pg_exporter, which installs only the first compatible
+one, so they must not be able to install on the same server.
+
+Queries are not run against a server; this only checks the definitions.
+
+Usage: python3 test/check_config.py [file ...]
+"""
+import glob
+import os
+import re
+import sys
+
+import yaml
+
+FIELDS = {'name', 'desc', 'query', 'ttl', 'timeout', 'min_version',
+          'max_version', 'fatal', 'skip', 'tags', 'metrics'}
+USAGES = {'DISCARD', 'LABEL', 'COUNTER', 'GAUGE'}
+METRIC_NAME = re.compile(r'^[a-zA-Z_:][a-zA-Z0-9_:]*$')
+LABEL_NAME = re.compile(r'^[a-zA-Z_][a-zA-Z0-9_]*$')
+
+
+def mask(sql):
+    """Blank out quoted text and anything inside parentheses, so only
+    the top level of the statement is left visible."""
+    out, depth, quote = [], 0, None
+    for c in sql:
+        if quote:
+            out.append(' ')
+            if c == quote:
+                quote = None
+        elif c in '\'"':
+            quote = c
+            out.append(' ')
+        elif c == '(':
+            depth += 1
+            out.append(' ')
+        elif c == ')':
+            depth -= 1
+            out.append(' ')
+        else:
+            out.append(c if depth == 0 else ' ')
+    return ''.join(out)
+
+
+def output_columns(sql):
+    """Return the output column names of the top-level SELECT."""
+    masked = mask(sql)
+    sel = re.search(r'\bSELECT\b', masked, re.I)
+    if not sel:
+        raise ValueError('no top-level SELECT')
+    end = re.search(r'\bFROM\b|;', masked[sel.end():], re.I)
+    stop = sel.end() + end.start() if end else len(sql)
+    cols, start = [], sel.end()
+    for i in [m.start() for m in re.finditer(',', masked[sel.end():stop])] + [stop - sel.end()]:
+        item, visible = sql[start:sel.end() + i], masked[start:sel.end() + i]
+        alias = re.search(r'\bAS\s+(\w+)\s*$', visible, re.I)
+        plain = re.match(r'^\s*(?:\w+\.)?(\w+)\s*$', item)
+        if alias:
+            cols.append(alias.group(1))
+        elif plain:
+            cols.append(plain.group(1))
+        else:
+            raise ValueError('unnamed output column: %s' % item.strip())
+        start = sel.end() + i + 1
+    return cols
+
+
+def versions(branch):
+    return branch.get('min_version', 0), branch.get('max_version', float('inf'))
+
+
+def exclusive(a, b):
+    """Report whether two branches can never install on the same server."""
+    (amin, amax), (bmin, bmax) = versions(a), versions(b)
+    if amax <= bmin or bmax <= amin:
+        return True
+    roles = {'primary': 'replica', 'replica': 'primary'}
+    return any(roles.get(t) in b.get('tags', []) for t in a.get('tags', []))
+
+
+def check_branch(key, branch):
+    errs = []
+    unknown = set(branch) - FIELDS
+    if unknown:
+        errs.append('unknown fields %s' % sorted(unknown))
+    for field in ('name', 'query', 'metrics'):
+        if field not in branch:
+            errs.append('missing %s' % field)
+    if errs:
+        return errs
+    defined = []
+    for m in branch['metrics']:
+        (col, spec), = m.items()
+        defined.append(col)
+        usage = spec.get('usage')
+        if usage not in USAGES:
+            errs.append('column %s: unknown usage %s' % (col, usage))
+        if not spec.get('description'):
+            errs.append('column %s: missing description' % col)
+        out = spec.get('rename', col)
+        if usage == 'LABEL' and not LABEL_NAME.match(out):
+            errs.append('column %s: invalid label name %s' % (col, out))
+        if usage in ('COUNTER', 'GAUGE') and not METRIC_NAME.match('%s_%s' % (branch['name'], out)):
+            errs.append('column %s: invalid metric name %s_%s' % (col, branch['name'], out))
+    try:
+        cols = output_columns(branch['query'])
+    except ValueError as e:
+        return errs + [str(e)]
+    if cols != defined:
+        errs.append('query returns %s, metrics define %s' % (cols, defined))
+    return errs
+
+
+def check_file(path):
+    errs, seen = [], {}
+    with open(path) as f:
+        doc = yaml.safe_load(f)
+    for key, branch in doc.items():
+        errs += ['%s: %s: %s' % (path, key, e) for e in check_branch(key, branch)]
+        for other_key, other in seen.get(branch.get('name'), []):
+            if not exclusive(branch, other):
+                errs.append('%s: %s: shares name %s with %s and would never install'
+                            % (path, key, branch['name'], other_key))
+        seen.setdefault(branch.get('name'), []).append((key, branch))
+    return errs
+
+
+def main(args):
+    root = os.path.join(os.path.dirname(os.path.abspath(__file__)), '..', 'config')
+    paths = args or sorted(glob.glob(os.path.join(root, '*.yml')))
+    errs = []
+    for path in paths:
+        errs += check_file(path)
+    for e in errs:
+        print(e, file=sys.stderr)
+    print('checked %d files, %d errors' % (len(paths), len(errs)))
+    return 1 if errs else 0
+
+
+if __name__ == '__main__':
+    sys.exit(main(sys.argv[1:]))